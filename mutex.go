@@ -1,14 +1,23 @@
 package redsync
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v7"
+	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/go-multierror"
 )
 
+// ErrWatchdogExtendFailed is sent on the channel returned by Mutex.Errors
+// when an auto-extend watchdog call to Extend comes back without quorum but
+// also without an error of its own; otherwise that error is sent instead.
+var ErrWatchdogExtendFailed = errors.New("redsync: watchdog failed to extend lock")
+
 // A DelayFunc is used to decide the amount of time to wait between retries.
 type DelayFunc func(tries int) time.Duration
 
@@ -25,85 +34,472 @@ type Mutex struct {
 	quorum int
 
 	genValueFunc func() (string, error)
-	value        string
-	until        time.Time
+
+	// mu guards the fields below, which are written by attemptLock/
+	// LockWithFenceContext on the caller's goroutine, while the auto-extend
+	// watchdog goroutine started by startWatchdog reads value through
+	// ExtendContext.
+	mu             sync.Mutex
+	value          string
+	until          time.Time
+	fence          int64
+	watchdogCancel context.CancelFunc
+	watchdogDone   chan struct{}
+	errCh          chan error
+
+	autoExtend         bool
+	autoExtendInterval time.Duration
+
+	observer Observer
 
 	pools []Pool
 }
 
+// fenceKeySuffix names the companion key that holds the monotonic fencing
+// counter for a mutex, relative to its name.
+const fenceKeySuffix = ":fence"
+
+// fenceTTLFactor sizes the fencing counter's TTL as a multiple of the lock's
+// own expiry, so the counter outlives a lock that simply expires and keeps
+// advancing across churn. The TTL is set only by fenced acquisitions; Extend
+// does not refresh it, so after that long without a fenced acquisition the
+// counter expires and the next token starts again from 1.
+const fenceTTLFactor = 10
+
+// Fence returns the fencing token obtained by the most recent successful
+// LockWithFence call, or 0 if the mutex was never locked with fencing
+// enabled. Pass it to downstream resources so they can reject writes from a
+// stale former lock holder.
+func (m *Mutex) Fence() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fence
+}
+
 // Lock locks m. In case it returns an error on failure, you may retry to acquire the lock by calling this method again.
 func (m *Mutex) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+// LockContext locks m like Lock does, but allows the caller to bound the
+// total time spent via ctx. If ctx is cancelled or its deadline is exceeded
+// while waiting between retries, the wait error is returned immediately.
+// ctx also bounds the lifetime of any auto-extend watchdog started by
+// WithAutoExtend: cancelling it stops the watchdog the same way Unlock
+// does.
+func (m *Mutex) LockContext(ctx context.Context) error {
+	return m.lockContext(ctx, ctx, false)
+}
+
+// TryLock attempts to lock m in a single pass: no sleeping, no retrying. It
+// returns (false, nil) immediately if quorum can't be reached right away,
+// which makes it suitable for a fast probe rather than a blocking wait. If no
+// instance granted the lock and any of them returned an error, that error is
+// returned instead.
+func (m *Mutex) TryLock() (bool, error) {
+	return m.TryLockContext(context.Background())
+}
+
+// TryLockContext attempts to lock m like TryLock does, but allows the
+// caller to cancel the single attempt via ctx.
+func (m *Mutex) TryLockContext(ctx context.Context) (bool, error) {
+	value, err := m.genValueFunc()
+	if err != nil {
+		return false, err
+	}
+	return m.attemptLock(ctx, ctx, value)
+}
+
+// LockWait locks m like Lock does, but keeps retrying until maxWait has
+// elapsed instead of giving up after m.tries attempts, still honoring ctx
+// cancellation and the delay between attempts produced by m.delayFunc. Note
+// that ctx, not the maxWait-bounded context derived from it, governs any
+// auto-extend watchdog: maxWait should only bound how long we wait to
+// acquire the lock, not how long we're allowed to hold it afterwards.
+func (m *Mutex) LockWait(ctx context.Context, maxWait time.Duration) error {
+	acquireCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	return m.lockContext(acquireCtx, ctx, true)
+}
+
+// lockContext runs the retry loop bounded by acquireCtx, but starts any
+// auto-extend watchdog against watchdogCtx instead. The two differ when
+// called from LockWait, where acquireCtx carries a maxWait deadline that
+// must not also cut the watchdog's lifetime short. When unlimited is true
+// (LockWait), the loop keeps retrying past m.tries until acquireCtx itself
+// gives up; otherwise (LockContext) it bails out with ErrFailed after
+// m.tries attempts.
+func (m *Mutex) lockContext(acquireCtx, watchdogCtx context.Context, unlimited bool) error {
 	value, err := m.genValueFunc()
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < m.tries; i++ {
+	for i := 0; unlimited || i < m.tries; i++ {
 		if i != 0 {
-			time.Sleep(m.delayFunc(i))
+			if err := sleepContext(acquireCtx, m.delayFunc(i)); err != nil {
+				return err
+			}
 		}
 
-		start := time.Now()
-
-		n, err := m.actOnPoolsAsync(func(pool Pool) (bool, error) {
-			return m.acquire(pool, value)
-		})
-		if n == 0 && err != nil {
+		ok, err := m.attemptLock(acquireCtx, watchdogCtx, value)
+		if err != nil {
 			return err
 		}
-
-		now := time.Now()
-		newValidityTime := m.expiry - now.Sub(start) - time.Duration(int64(float64(m.expiry)*m.factor))
-		if n >= m.quorum && newValidityTime > 0 {
-			m.value = value
-			m.until = now.Add(newValidityTime)
+		if ok {
 			return nil
 		}
-		m.actOnPoolsAsync(func(pool Pool) (bool, error) {
-			return m.release(pool, value)
-		})
 	}
 
 	return ErrFailed
 }
 
+// attemptLock makes a single attempt to acquire the lock with the given
+// value across the quorum of pools, releasing again on the instances that
+// did acquire it if quorum wasn't reached. watchdogCtx bounds the lifetime
+// of the auto-extend watchdog started on success; see lockContext.
+func (m *Mutex) attemptLock(ctx, watchdogCtx context.Context, value string) (bool, error) {
+	start := time.Now()
+
+	n, errs := m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		attemptStart := time.Now()
+		ok, err := m.acquire(ctx, pool, value)
+		if m.observer != nil {
+			m.observer.OnAcquireAttempt(m.name, i, ok, time.Since(attemptStart))
+		}
+		return ok, err
+	})
+	err := mergeErrors(errs)
+	if n == 0 && err != nil {
+		if m.observer != nil {
+			m.observer.OnAcquireResult(m.name, value, m.quorum, n, 0, err)
+		}
+		return false, err
+	}
+
+	now := time.Now()
+	newValidityTime := m.expiry - now.Sub(start) - time.Duration(int64(float64(m.expiry)*m.factor))
+	if n >= m.quorum && newValidityTime > 0 {
+		m.setLockState(value, now.Add(newValidityTime))
+		m.startWatchdog(watchdogCtx)
+		if m.observer != nil {
+			m.observer.OnAcquireResult(m.name, value, m.quorum, n, newValidityTime, nil)
+		}
+		return true, nil
+	}
+	m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		return m.release(ctx, pool, value)
+	})
+	if m.observer != nil {
+		m.observer.OnAcquireResult(m.name, value, m.quorum, n, 0, ErrFailed)
+	}
+	return false, nil
+}
+
+// LockWithFence locks m like Lock does, and additionally returns a fencing
+// token: a strictly monotonically increasing int64 that downstream
+// resources can use to reject writes from a stale former lock holder (see
+// Martin Kleppmann's critique of using Redlock alone for that purpose). The
+// same token is later available via Fence.
+//
+// The token stays monotonic even as the specific subset of reachable
+// instances changes between acquisitions (an instance that held the
+// previous high-water mark going down, a new one joining): each acquisition
+// first reads the fence counter from every reachable instance and computes
+// one past the highest value seen, then writes that same value to every
+// instance it locks, rather than letting each instance maintain its own
+// independent counter. Any two quorums overlap in at least one instance, so
+// the next acquisition is guaranteed to observe the previous high-water
+// mark through that overlap.
+func (m *Mutex) LockWithFence() (int64, error) {
+	return m.LockWithFenceContext(context.Background())
+}
+
+// LockWithFenceContext locks m like LockWithFence does, but allows the
+// caller to bound the total time spent via ctx.
+func (m *Mutex) LockWithFenceContext(ctx context.Context) (int64, error) {
+	value, err := m.genValueFunc()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < m.tries; i++ {
+		if i != 0 {
+			if err := sleepContext(ctx, m.delayFunc(i)); err != nil {
+				return 0, err
+			}
+		}
+
+		ok, fence, err := m.attemptLockWithFence(ctx, ctx, value)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return fence, nil
+		}
+	}
+
+	return 0, ErrFailed
+}
+
+// attemptLockWithFence makes a single fenced acquisition attempt. It mirrors
+// attemptLock's structure and Observer plumbing, additionally reading and
+// writing the monotonic fence counter described on LockWithFence.
+// watchdogCtx bounds the lifetime of the auto-extend watchdog started on
+// success; see lockContext.
+func (m *Mutex) attemptLockWithFence(ctx, watchdogCtx context.Context, value string) (bool, int64, error) {
+	start := time.Now()
+	nextFence := m.readMaxFence(ctx) + 1
+
+	n, errs := m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		attemptStart := time.Now()
+		ok, err := m.acquireWithFence(ctx, pool, value, nextFence)
+		if m.observer != nil {
+			m.observer.OnAcquireAttempt(m.name, i, ok, time.Since(attemptStart))
+		}
+		return ok, err
+	})
+	err := mergeErrors(errs)
+	if n == 0 && err != nil {
+		if m.observer != nil {
+			m.observer.OnAcquireResult(m.name, value, m.quorum, n, 0, err)
+		}
+		return false, 0, err
+	}
+
+	now := time.Now()
+	newValidityTime := m.expiry - now.Sub(start) - time.Duration(int64(float64(m.expiry)*m.factor))
+	if n >= m.quorum && newValidityTime > 0 {
+		m.setLockState(value, now.Add(newValidityTime))
+		m.setFence(nextFence)
+		m.startWatchdog(watchdogCtx)
+		if m.observer != nil {
+			m.observer.OnAcquireResult(m.name, value, m.quorum, n, newValidityTime, nil)
+		}
+		return true, nextFence, nil
+	}
+	m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		return m.release(ctx, pool, value)
+	})
+	if m.observer != nil {
+		m.observer.OnAcquireResult(m.name, value, m.quorum, n, 0, ErrFailed)
+	}
+	return false, 0, nil
+}
+
+// readMaxFence returns the highest fencing counter it can read across all
+// pools (0 if none), ignoring unreachable instances; the caller adds one. It is the "read"
+// half of the read-quorum-then-write-quorum register redsync uses to keep
+// fencing tokens monotonic despite which specific instances respond
+// varying between acquisitions.
+func (m *Mutex) readMaxFence(ctx context.Context) int64 {
+	type result struct {
+		fence int64
+	}
+
+	ch := make(chan result, len(m.pools))
+	for _, pool := range m.pools {
+		go func(pool Pool) {
+			conn := pool.Get(ctx)
+			defer conn.Close()
+			reply, err := conn.Get(ctx, m.name+fenceKeySuffix).Result()
+			if err != nil {
+				ch <- result{}
+				return
+			}
+			fence, err := strconv.ParseInt(reply, 10, 64)
+			if err != nil {
+				ch <- result{}
+				return
+			}
+			ch <- result{fence: fence}
+		}(pool)
+	}
+
+	var max int64
+	for range m.pools {
+		if r := <-ch; r.fence > max {
+			max = r.fence
+		}
+	}
+	return max
+}
+
 // Unlock unlocks m and returns the status of unlock.
 func (m *Mutex) Unlock() (bool, error) {
-	n, err := m.actOnPoolsAsync(func(pool Pool) (bool, error) {
-		return m.release(pool, m.value)
+	return m.UnlockContext(context.Background())
+}
+
+// UnlockContext unlocks m like Unlock does, but allows the caller to cancel
+// the release call via ctx.
+func (m *Mutex) UnlockContext(ctx context.Context) (bool, error) {
+	m.stopWatchdog()
+
+	value := m.lockedValue()
+	n, errs := m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		return m.release(ctx, pool, value)
 	})
-	if n < m.quorum {
+	err := mergeErrors(errs)
+	ok := n >= m.quorum
+	if m.observer != nil {
+		m.observer.OnRelease(m.name, ok, err)
+	}
+	if !ok {
 		return false, err
 	}
 	return true, nil
 }
 
+// Errors returns a channel on which Extend failures from the auto-extend
+// watchdog are delivered. It is only populated when the mutex was
+// configured with WithAutoExtend; otherwise it returns nil.
+func (m *Mutex) Errors() <-chan error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errCh
+}
+
+// startWatchdog spawns the auto-extend goroutine if the mutex was
+// configured with WithAutoExtend. It is a no-op otherwise. The goroutine
+// runs until Unlock calls stopWatchdog or ctx (the context the lock was
+// acquired with) is cancelled, whichever comes first.
+func (m *Mutex) startWatchdog(ctx context.Context) {
+	if !m.autoExtend {
+		return
+	}
+
+	interval := m.autoExtendInterval
+	if interval <= 0 {
+		interval = m.expiry / 3
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	errCh := make(chan error, 1)
+
+	m.mu.Lock()
+	m.watchdogCancel = cancel
+	m.watchdogDone = done
+	m.errCh = errCh
+	m.mu.Unlock()
+
+	go m.runWatchdog(wctx, interval, done, errCh)
+}
+
+// stopWatchdog cancels a running auto-extend goroutine, if any, and blocks
+// until it has actually returned, so the caller can safely reuse m (e.g. for
+// a subsequent Lock) without racing the watchdog's reads of the lock state.
+func (m *Mutex) stopWatchdog() {
+	m.mu.Lock()
+	cancel := m.watchdogCancel
+	done := m.watchdogDone
+	m.watchdogCancel = nil
+	m.watchdogDone = nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (m *Mutex) runWatchdog(ctx context.Context, interval time.Duration, done chan struct{}, errCh chan error) {
+	defer close(done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ok, err := m.ExtendContext(ctx)
+			if err == nil && !ok {
+				err = ErrWatchdogExtendFailed
+			}
+			if err != nil {
+				if m.observer != nil {
+					m.observer.OnLost(m.name, err)
+				}
+				select {
+				case errCh <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
 // Extend resets the mutex's expiry and returns the status of expiry extension.
 func (m *Mutex) Extend() (bool, error) {
-	n, err := m.actOnPoolsAsync(func(pool Pool) (bool, error) {
-		return m.touch(pool, m.value, int(m.expiry/time.Millisecond))
+	return m.ExtendContext(context.Background())
+}
+
+// ExtendContext resets the mutex's expiry like Extend does, but allows the
+// caller to cancel the touch call via ctx.
+func (m *Mutex) ExtendContext(ctx context.Context) (bool, error) {
+	value := m.lockedValue()
+	n, errs := m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		return m.touch(ctx, pool, value, int(m.expiry/time.Millisecond))
 	})
-	if n < m.quorum {
+	err := mergeErrors(errs)
+	ok := n >= m.quorum
+	if m.observer != nil {
+		m.observer.OnExtend(m.name, ok, err)
+	}
+	if !ok {
 		return false, err
 	}
 	return true, nil
 }
 
 func (m *Mutex) Valid() (bool, error) {
-	n, err := m.actOnPoolsAsync(func(pool Pool) (bool, error) {
-		return m.valid(pool)
+	return m.ValidContext(context.Background())
+}
+
+// ValidContext reports whether the lock is still held like Valid does, but
+// allows the caller to cancel the check via ctx.
+func (m *Mutex) ValidContext(ctx context.Context) (bool, error) {
+	value := m.lockedValue()
+	n, errs := m.actOnPoolsAsync(ctx, func(ctx context.Context, i int, pool Pool) (bool, error) {
+		return m.valid(ctx, pool, value)
 	})
-	return n >= m.quorum, err
+	return n >= m.quorum, mergeErrors(errs)
 }
 
-func (m *Mutex) valid(pool Pool) (bool, error) {
-	conn := pool.Get()
+func (m *Mutex) valid(ctx context.Context, pool Pool, value string) (bool, error) {
+	conn := pool.Get(ctx)
 	defer conn.Close()
-	reply, err := conn.Get(m.name).Result()
+	reply, err := conn.Get(ctx, m.name).Result()
 	if err != nil {
 		return false, err
 	}
-	return m.value == reply, nil
+	return value == reply, nil
+}
+
+// setLockState records a successful acquisition's value and expiry under mu,
+// so the watchdog goroutine's ExtendContext calls can't race with it.
+func (m *Mutex) setLockState(value string, until time.Time) {
+	m.mu.Lock()
+	m.value = value
+	m.until = until
+	m.mu.Unlock()
+}
+
+// setFence records a successful LockWithFenceContext acquisition's token.
+func (m *Mutex) setFence(fence int64) {
+	m.mu.Lock()
+	m.fence = fence
+	m.mu.Unlock()
+}
+
+// lockedValue returns the current lock value under mu.
+func (m *Mutex) lockedValue() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.value
 }
 
 func genValue() (string, error) {
@@ -115,10 +511,10 @@ func genValue() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-func (m *Mutex) acquire(pool Pool, value string) (bool, error) {
-	conn := pool.Get()
+func (m *Mutex) acquire(ctx context.Context, pool Pool, value string) (bool, error) {
+	conn := pool.Get(ctx)
 	defer conn.Close()
-	reply, err := conn.SetNX(m.name, value, m.expiry).Result()
+	reply, err := conn.SetNX(ctx, m.name, value, m.expiry).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return false, nil
@@ -128,6 +524,31 @@ func (m *Mutex) acquire(pool Pool, value string) (bool, error) {
 	return reply, nil
 }
 
+// fenceAcquireScript takes the fencing token to apply, computed ahead of
+// time by readMaxFence, as ARGV[3], rather than deriving it from this one
+// instance's own counter via INCR: every instance that grants the lock
+// ends up agreeing on the same fence value for this acquisition.
+var fenceAcquireScript = redis.NewScript(`
+	if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+		redis.call("SET", KEYS[2], ARGV[3], "PX", ARGV[4])
+		return tonumber(ARGV[3])
+	else
+		return 0
+	end
+`)
+
+func (m *Mutex) acquireWithFence(ctx context.Context, pool Pool, value string, fence int64) (bool, error) {
+	conn := pool.Get(ctx)
+	defer conn.Close()
+	expiryMs := int(m.expiry / time.Millisecond)
+	reply, err := fenceAcquireScript.Run(ctx, conn, []string{m.name, m.name + fenceKeySuffix}, value, expiryMs, fence, expiryMs*fenceTTLFactor).Result()
+	if err != nil {
+		return false, err
+	}
+	status, ok := reply.(int64)
+	return ok && status != 0, nil
+}
+
 var deleteScript = redis.NewScript(`
 	if redis.call("GET", KEYS[1]) == ARGV[1] then
 		return redis.call("DEL", KEYS[1])
@@ -136,10 +557,10 @@ var deleteScript = redis.NewScript(`
 	end
 `)
 
-func (m *Mutex) release(pool Pool, value string) (bool, error) {
-	conn := pool.Get()
+func (m *Mutex) release(ctx context.Context, pool Pool, value string) (bool, error) {
+	conn := pool.Get(ctx)
 	defer conn.Close()
-	status, err := deleteScript.Run(conn, []string{m.name}, value).Result()
+	status, err := deleteScript.Run(ctx, conn, []string{m.name}, value).Result()
 
 	return err == nil && status != 0, err
 }
@@ -152,37 +573,73 @@ var touchScript = redis.NewScript(`
 	end
 `)
 
-func (m *Mutex) touch(pool Pool, value string, expiry int) (bool, error) {
-	conn := pool.Get()
+func (m *Mutex) touch(ctx context.Context, pool Pool, value string, expiry int) (bool, error) {
+	conn := pool.Get(ctx)
 	defer conn.Close()
-	status, err := touchScript.Run(conn, []string{m.name}, value, expiry).Result()
+	status, err := touchScript.Run(ctx, conn, []string{m.name}, value, expiry).Result()
 
 	return err == nil && status != 0, err
 }
 
-func (m *Mutex) actOnPoolsAsync(actFn func(Pool) (bool, error)) (int, error) {
+// actOnPoolsAsync runs actFn against every pool concurrently and returns how
+// many reported success along with a per-pool error slice (indexed the same
+// as m.pools, nil entries for pools that had no error), so observers can
+// attribute failures to specific instances instead of a single merged
+// error.
+func (m *Mutex) actOnPoolsAsync(ctx context.Context, actFn func(ctx context.Context, i int, pool Pool) (bool, error)) (int, []error) {
 	type result struct {
+		Index  int
 		Status bool
 		Err    error
 	}
 
 	ch := make(chan result)
-	for _, pool := range m.pools {
-		go func(pool Pool) {
-			r := result{}
-			r.Status, r.Err = actFn(pool)
+	for i, pool := range m.pools {
+		go func(i int, pool Pool) {
+			r := result{Index: i}
+			r.Status, r.Err = actFn(ctx, i, pool)
 			ch <- r
-		}(pool)
+		}(i, pool)
 	}
 	n := 0
-	var err error
+	errs := make([]error, len(m.pools))
 	for range m.pools {
 		r := <-ch
 		if r.Status {
 			n++
-		} else if r.Err != nil {
-			err = multierror.Append(err, r.Err)
 		}
+		errs[r.Index] = r.Err
+	}
+	return n, errs
+}
+
+// mergeErrors collapses a per-pool error slice, as returned by
+// actOnPoolsAsync, into a single multierror for callers that only need to
+// know whether anything went wrong.
+func mergeErrors(errs []error) error {
+	var merged error
+	for _, err := range errs {
+		if err != nil {
+			merged = multierror.Append(merged, err)
+		}
+	}
+	return merged
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is
+// cancelled or its deadline is exceeded before d elapses.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
-	return n, err
 }
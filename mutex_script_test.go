@@ -0,0 +1,63 @@
+package redsync
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// realRedisClient dials a live Redis server so tests can exercise actual Lua
+// script evaluation and RESP wire encoding, rather than fakeConn's
+// native-Go reimplementation of the scripts' bodies used elsewhere in this
+// package's tests - that reimplementation can't catch a bug in the scripts
+// themselves, like returning a value that decodes to the wrong Go type. It
+// skips the calling test if no server is reachable.
+func realRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		t.Skipf("no Redis server reachable at %s to exercise real Lua/RESP encoding: %v", addr, err)
+	}
+	return client
+}
+
+// TestFenceAcquireScriptRealRESPEncoding runs fenceAcquireScript against a
+// real Redis server. Lua's ARGV elements are always strings, so returning
+// ARGV[3] directly (as a prior version of this script did) sends back a
+// RESP bulk string instead of an integer reply - acquireWithFence's
+// reply.(int64) assertion then always fails against a real server, even
+// though fakeConn's Go reimplementation of this script hands back a native
+// int64 and never catches it.
+func TestFenceAcquireScriptRealRESPEncoding(t *testing.T) {
+	client := realRedisClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	name := "fence-script-real-test-lock"
+	fenceKey := name + fenceKeySuffix
+	client.Del(ctx, name, fenceKey)
+	defer client.Del(ctx, name, fenceKey)
+
+	reply, err := fenceAcquireScript.Run(ctx, client, []string{name, fenceKey}, "holder-1", 1000, int64(1), 10000).Result()
+	if err != nil {
+		t.Fatalf("script run: %v", err)
+	}
+	fence, ok := reply.(int64)
+	if !ok {
+		t.Fatalf("expected script to return an int64 fence token over RESP, got %T(%v)", reply, reply)
+	}
+	if fence != 1 {
+		t.Fatalf("expected fence 1, got %d", fence)
+	}
+}
@@ -0,0 +1,333 @@
+package redsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeInstance simulates a single independent Redis instance's keyspace, so
+// tests can exercise Mutex without a real Redis server.
+type fakeInstance struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newFakeInstance() *fakeInstance {
+	return &fakeInstance{values: map[string]string{}}
+}
+
+// fakePool adapts a fakeInstance to the Pool interface.
+type fakePool struct {
+	inst *fakeInstance
+}
+
+func (p *fakePool) Get(ctx context.Context) RedisConn {
+	return &fakeConn{inst: p.inst}
+}
+
+// fakeConn implements RedisConn against a fakeInstance. It recognizes the
+// three scripts redsync ships by their hash, and reimplements their Lua
+// bodies directly in Go, so tests don't need an embedded Lua interpreter.
+type fakeConn struct {
+	inst *fakeInstance
+}
+
+func (c *fakeConn) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	c.inst.mu.Lock()
+	v, ok := c.inst.values[key]
+	c.inst.mu.Unlock()
+	if !ok {
+		cmd.SetErr(redis.Nil)
+		return cmd
+	}
+	cmd.SetVal(v)
+	return cmd
+}
+
+func (c *fakeConn) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	cmd := redis.NewBoolCmd(ctx)
+	c.inst.mu.Lock()
+	_, exists := c.inst.values[key]
+	if !exists {
+		c.inst.values[key] = fmt.Sprint(value)
+	}
+	c.inst.mu.Unlock()
+	cmd.SetVal(!exists)
+	return cmd
+}
+
+func (c *fakeConn) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+	switch sha1 {
+	case fenceAcquireScript.Hash():
+		cmd.SetVal(c.evalFenceAcquire(keys, args))
+	case deleteScript.Hash():
+		cmd.SetVal(c.evalDelete(keys, args))
+	case touchScript.Hash():
+		cmd.SetVal(c.evalTouch(keys, args))
+	default:
+		cmd.SetErr(fmt.Errorf("fakeConn: unrecognized script %s", sha1))
+	}
+	return cmd
+}
+
+func (c *fakeConn) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+	cmd.SetErr(errors.New("fakeConn: Eval is unsupported, scripts are dispatched by hash in EvalSha"))
+	return cmd
+}
+
+func (c *fakeConn) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	cmd := redis.NewBoolSliceCmd(ctx)
+	exists := make([]bool, len(hashes))
+	for i := range exists {
+		exists[i] = true
+	}
+	cmd.SetVal(exists)
+	return cmd
+}
+
+func (c *fakeConn) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	cmd.SetVal("")
+	return cmd
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) evalFenceAcquire(keys []string, args []interface{}) int64 {
+	c.inst.mu.Lock()
+	defer c.inst.mu.Unlock()
+	name, fenceKey := keys[0], keys[1]
+	if _, exists := c.inst.values[name]; exists {
+		return 0
+	}
+	c.inst.values[name] = fmt.Sprint(args[0])
+	fence := args[2].(int64)
+	c.inst.values[fenceKey] = strconv.FormatInt(fence, 10)
+	return fence
+}
+
+func (c *fakeConn) evalDelete(keys []string, args []interface{}) int64 {
+	c.inst.mu.Lock()
+	defer c.inst.mu.Unlock()
+	name := keys[0]
+	if c.inst.values[name] != fmt.Sprint(args[0]) {
+		return 0
+	}
+	delete(c.inst.values, name)
+	return 1
+}
+
+func (c *fakeConn) evalTouch(keys []string, args []interface{}) int64 {
+	c.inst.mu.Lock()
+	defer c.inst.mu.Unlock()
+	name := keys[0]
+	if c.inst.values[name] != fmt.Sprint(args[0]) {
+		return 0
+	}
+	return 1
+}
+
+// newTestMutex builds a Mutex with sane, fast-failing defaults for tests,
+// applying any additional options.
+func newTestMutex(name string, pools []Pool, opts ...Option) *Mutex {
+	m := &Mutex{
+		name:         name,
+		expiry:       50 * time.Millisecond,
+		tries:        1,
+		delayFunc:    func(int) time.Duration { return 5 * time.Millisecond },
+		factor:       0.01,
+		quorum:       len(pools),
+		genValueFunc: genValue,
+		pools:        pools,
+	}
+	for _, o := range opts {
+		o.Apply(m)
+	}
+	return m
+}
+
+// TestLockWithFenceMonotonicAcrossInstanceChurn reproduces the scenario
+// where the specific subset of reachable instances changes between two
+// acquisitions of the same named lock: the fencing token must still never
+// go backwards, or it defeats the whole point of fencing (see Kleppmann's
+// critique of Redlock).
+func TestLockWithFenceMonotonicAcrossInstanceChurn(t *testing.T) {
+	x := newFakeInstance()
+	y := newFakeInstance()
+	z := newFakeInstance()
+
+	// x and y already carry forward a counter from a prior holder.
+	x.values["my-lock:fence"] = "100"
+	y.values["my-lock:fence"] = "100"
+
+	m1 := newTestMutex("my-lock", []Pool{&fakePool{x}, &fakePool{y}})
+	fence1, err := m1.LockWithFence()
+	if err != nil {
+		t.Fatalf("first LockWithFence: %v", err)
+	}
+	if fence1 <= 100 {
+		t.Fatalf("expected fence1 > 100, got %d", fence1)
+	}
+	if _, err := m1.Unlock(); err != nil {
+		t.Fatalf("unlock first holder: %v", err)
+	}
+
+	// x goes down and is replaced by fresh instance z, which has never seen
+	// the counter.
+	m2 := newTestMutex("my-lock", []Pool{&fakePool{y}, &fakePool{z}})
+	fence2, err := m2.LockWithFence()
+	if err != nil {
+		t.Fatalf("second LockWithFence: %v", err)
+	}
+	if fence2 <= fence1 {
+		t.Fatalf("fencing token went backwards: fence1=%d fence2=%d", fence1, fence2)
+	}
+}
+
+// countingObserver counts callbacks by kind so tests can tell whether the
+// auto-extend watchdog is still ticking, or whether a given lock call
+// reported through the Observer at all.
+type countingObserver struct {
+	mu       sync.Mutex
+	attempts int
+	results  int
+	extends  int
+}
+
+func (o *countingObserver) OnAcquireAttempt(name string, instance int, ok bool, dur time.Duration) {
+	o.mu.Lock()
+	o.attempts++
+	o.mu.Unlock()
+}
+func (o *countingObserver) OnAcquireResult(name, value string, quorum, got int, validity time.Duration, err error) {
+	o.mu.Lock()
+	o.results++
+	o.mu.Unlock()
+}
+func (o *countingObserver) OnRelease(name string, ok bool, err error) {}
+func (o *countingObserver) OnExtend(name string, ok bool, err error) {
+	o.mu.Lock()
+	o.extends++
+	o.mu.Unlock()
+}
+func (o *countingObserver) OnLost(name string, err error) {}
+
+func (o *countingObserver) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.extends
+}
+
+func (o *countingObserver) counts() (attempts, results int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.attempts, o.results
+}
+
+// TestWatchdogStopsWhenLockContextCancelled verifies that cancelling the
+// context a lock was acquired with stops the auto-extend watchdog, not just
+// calling Unlock.
+func TestWatchdogStopsWhenLockContextCancelled(t *testing.T) {
+	inst := newFakeInstance()
+	obs := &countingObserver{}
+	m := newTestMutex("watchdog-ctx-lock", []Pool{&fakePool{inst}}, WithAutoExtend(5*time.Millisecond), WithObserver(obs))
+	m.expiry = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.LockContext(ctx); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	countAtCancel := obs.count()
+	time.Sleep(30 * time.Millisecond)
+
+	if got := obs.count(); got > countAtCancel+1 {
+		t.Fatalf("watchdog kept extending after ctx cancellation: %d extends before cancel vs %d after", countAtCancel, got)
+	}
+
+	if _, err := m.Unlock(); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+}
+
+// TestReuseAfterAutoExtendIsRaceFree reproduces a data race between the
+// auto-extend watchdog goroutine from one acquisition and attemptLock's
+// writes on a later acquisition of the same *Mutex: stopWatchdog must block
+// until the watchdog goroutine has actually exited before Lock reuses the
+// mutex, or this fails under `go test -race`.
+func TestReuseAfterAutoExtendIsRaceFree(t *testing.T) {
+	inst := newFakeInstance()
+	m := newTestMutex("reuse-lock", []Pool{&fakePool{inst}}, WithAutoExtend(2*time.Millisecond))
+	m.expiry = 20 * time.Millisecond
+
+	for i := 0; i < 20; i++ {
+		if err := m.Lock(); err != nil {
+			t.Fatalf("lock %d: %v", i, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, err := m.Unlock(); err != nil {
+			t.Fatalf("unlock %d: %v", i, err)
+		}
+	}
+}
+
+// TestLockWithFenceReportsToObserver verifies that LockWithFenceContext
+// routes through the same Observer plumbing as LockContext, rather than the
+// old duplicated retry loop that never called OnAcquireAttempt/OnAcquireResult.
+func TestLockWithFenceReportsToObserver(t *testing.T) {
+	inst := newFakeInstance()
+	obs := &countingObserver{}
+	m := newTestMutex("fenced-observer-lock", []Pool{&fakePool{inst}}, WithObserver(obs))
+
+	if _, err := m.LockWithFence(); err != nil {
+		t.Fatalf("LockWithFence: %v", err)
+	}
+
+	attempts, results := obs.counts()
+	if attempts == 0 {
+		t.Fatal("expected OnAcquireAttempt to be called for a fenced lock")
+	}
+	if results == 0 {
+		t.Fatal("expected OnAcquireResult to be called for a fenced lock")
+	}
+}
+
+// TestLockWaitRetriesPastTries verifies that LockWait keeps retrying until
+// maxWait elapses even once m.tries attempts have already failed, as its
+// doc comment promises.
+func TestLockWaitRetriesPastTries(t *testing.T) {
+	inst := newFakeInstance()
+	inst.values["retry-lock"] = "held-by-someone-else"
+
+	m := newTestMutex("retry-lock", []Pool{&fakePool{inst}})
+	m.tries = 1
+	m.delayFunc = func(int) time.Duration { return 5 * time.Millisecond }
+
+	go func() {
+		time.Sleep(40 * time.Millisecond)
+		inst.mu.Lock()
+		delete(inst.values, "retry-lock")
+		inst.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := m.LockWait(context.Background(), 200*time.Millisecond); err != nil {
+		t.Fatalf("LockWait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("LockWait returned too quickly to have retried past tries=%d: %v", m.tries, elapsed)
+	}
+}
@@ -0,0 +1,33 @@
+package redsync
+
+import "time"
+
+// An Observer receives callbacks about a Mutex's lock lifecycle, so metrics
+// and tracing can be wired up without the locking logic needing to know
+// about either. Callbacks are invoked synchronously and should return
+// quickly. OnAcquireAttempt is called concurrently from one goroutine per
+// instance, and the auto-extend watchdog calls OnExtend and OnLost from its
+// own goroutine, so implementations must be safe for concurrent use.
+type Observer interface {
+	// OnAcquireAttempt is called once per instance for every acquire request
+	// (SETNX, or the fencing script for LockWithFence) sent while trying to
+	// acquire name, reporting whether that single instance succeeded and how
+	// long it took.
+	OnAcquireAttempt(name string, instance int, ok bool, dur time.Duration)
+
+	// OnAcquireResult is called once per Lock/LockContext/TryLock/LockWait/
+	// LockWithFence attempt with the outcome across all instances: the quorum
+	// needed, how many instances granted the lock, the remaining validity
+	// time on success, and any error.
+	OnAcquireResult(name, value string, quorum, got int, validity time.Duration, err error)
+
+	// OnRelease is called once per Unlock/UnlockContext call.
+	OnRelease(name string, ok bool, err error)
+
+	// OnExtend is called once per Extend/ExtendContext call.
+	OnExtend(name string, ok bool, err error)
+
+	// OnLost is called when an auto-extend watchdog (see WithAutoExtend)
+	// fails to extend the lock, meaning it may have already been lost.
+	OnLost(name string, err error)
+}
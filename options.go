@@ -0,0 +1,41 @@
+package redsync
+
+import "time"
+
+// An Option configures a Mutex.
+type Option interface {
+	Apply(*Mutex)
+}
+
+// OptionFunc is a function that configures a Mutex.
+type OptionFunc func(*Mutex)
+
+// Apply calls f(m).
+func (f OptionFunc) Apply(m *Mutex) {
+	f(m)
+}
+
+// WithAutoExtend opts a Mutex into a watchdog goroutine: once Lock (or
+// LockContext) succeeds, the watchdog calls Extend roughly every interval
+// until Unlock is called, keeping a long-running critical section alive
+// without the caller having to hand-roll a refresh loop. If interval is
+// zero or negative, the watchdog instead extends every expiry/3.
+//
+// Extension failures are delivered on the channel returned by Mutex.Errors
+// rather than stopping the watchdog, since a transient failure doesn't mean
+// the lock has been lost - the caller should consult Valid if it needs to
+// know for certain.
+func WithAutoExtend(interval time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.autoExtendInterval = interval
+		m.autoExtend = true
+	})
+}
+
+// WithObserver registers o to receive callbacks about the Mutex's lock
+// lifecycle. See the Observer interface for what gets reported.
+func WithObserver(o Observer) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.observer = o
+	})
+}
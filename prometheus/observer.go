@@ -0,0 +1,63 @@
+// Package prometheus provides a Prometheus-backed redsync.Observer exposing
+// redsync_lock_attempts_total, redsync_lock_acquire_duration_seconds and
+// redsync_lock_quorum_failures_total.
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Danceiny/redsync"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Observer implements redsync.Observer by recording Mutex lock activity as
+// Prometheus metrics. Metrics are deliberately not labeled by lock name:
+// names are commonly per-resource (e.g. "order:12345"), and a label on an
+// unbounded set of values is a well-known way to blow up a Prometheus
+// instance's cardinality.
+type Observer struct {
+	attemptsTotal   *prometheus.CounterVec
+	acquireDuration prometheus.Histogram
+	quorumFailures  prometheus.Counter
+}
+
+var _ redsync.Observer = (*Observer)(nil)
+
+// NewObserver creates an Observer and registers its metrics with reg. Pass
+// prometheus.DefaultRegisterer to use the global registry.
+func NewObserver(reg prometheus.Registerer) *Observer {
+	o := &Observer{
+		attemptsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "redsync_lock_attempts_total",
+			Help: "Total number of per-instance lock acquire attempts, labeled by outcome.",
+		}, []string{"ok"}),
+		acquireDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "redsync_lock_acquire_duration_seconds",
+			Help: "Time spent on each per-instance lock acquire attempt.",
+		}),
+		quorumFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "redsync_lock_quorum_failures_total",
+			Help: "Total number of acquire attempts that failed to reach quorum.",
+		}),
+	}
+	reg.MustRegister(o.attemptsTotal, o.acquireDuration, o.quorumFailures)
+	return o
+}
+
+func (o *Observer) OnAcquireAttempt(name string, instance int, ok bool, dur time.Duration) {
+	o.attemptsTotal.WithLabelValues(strconv.FormatBool(ok)).Inc()
+	o.acquireDuration.Observe(dur.Seconds())
+}
+
+func (o *Observer) OnAcquireResult(name, value string, quorum, got int, validity time.Duration, err error) {
+	if got < quorum {
+		o.quorumFailures.Inc()
+	}
+}
+
+func (o *Observer) OnRelease(name string, ok bool, err error) {}
+
+func (o *Observer) OnExtend(name string, ok bool, err error) {}
+
+func (o *Observer) OnLost(name string, err error) {}
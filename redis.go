@@ -1,8 +1,32 @@
 package redsync
 
-import "github.com/go-redis/redis/v7"
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisConn is the subset of Redis client behavior a Mutex needs in order to
+// acquire, release, extend and validate a lock. It is satisfied directly by
+// *redis.Client, *redis.ClusterClient and *redis.Conn, so a Pool can hand
+// back a single dedicated connection, a Sentinel-backed failover client, or
+// a Cluster client depending on how the instance is deployed.
+type RedisConn interface {
+	Get(ctx context.Context, key string) *redis.StringCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+	Close() error
+}
 
 // A Pool maintains a pool of Redis connections.
 type Pool interface {
-	Get() redis.Conn
+	// Get returns a RedisConn for a single Redis operation. redsync calls
+	// Close on it immediately afterwards, so implementations backed by a
+	// shared, long-lived client (e.g. *redis.ClusterClient) must hand back
+	// something whose Close releases rather than destroys that client.
+	Get(ctx context.Context) RedisConn
 }
@@ -0,0 +1,142 @@
+// Package redis provides Redis Sentinel and Redis Cluster backed
+// implementations of redsync.Pool, so that the instances making up a
+// Mutex's Redlock quorum can be Sentinel-managed masters or hashed-key
+// slots in a cluster instead of independent standalone Redis servers.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Danceiny/redsync"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// sentinelPool adapts a Sentinel-managed failover client to redsync.Pool.
+type sentinelPool struct {
+	client *goredis.Client
+}
+
+// NewSentinelPool returns a Pool backed by a Redis Sentinel deployment. It
+// connects to sentinelAddrs to discover the current master for masterName
+// and transparently follows failovers. opts may be nil; MasterName and
+// SentinelAddrs are always overwritten with masterName and sentinelAddrs.
+func NewSentinelPool(masterName string, sentinelAddrs []string, opts *goredis.FailoverOptions) redsync.Pool {
+	if opts == nil {
+		opts = &goredis.FailoverOptions{}
+	}
+	opts.MasterName = masterName
+	opts.SentinelAddrs = sentinelAddrs
+
+	return &sentinelPool{client: goredis.NewFailoverClient(opts)}
+}
+
+// Get returns a dedicated connection pulled from the client's own pool.
+// redsync calls Close on the RedisConn it gets back after every single
+// operation, so this can't just hand back the shared *goredis.Client: its
+// Close tears down the whole client, not a single connection. Conn gives us
+// exactly that per-call connection, with Close releasing it back to the
+// pool instead.
+func (p *sentinelPool) Get(ctx context.Context) redsync.RedisConn {
+	return p.client.Conn(ctx)
+}
+
+// clusterPool adapts a Redis Cluster client to redsync.Pool.
+type clusterPool struct {
+	client  *goredis.ClusterClient
+	hashTag string
+}
+
+// NewClusterPool returns a Pool backed by clusterClient. Every key redsync
+// operates on through this Pool is forced under hashTag (see
+// ensureHashTag), so the lock key and any companion keys redsync derives
+// from it - like the fencing counter LockWithFence uses - always land on
+// the same hash slot. That keeps the multi-key Lua scripts redsync runs
+// from failing with CROSSSLOT, without relying on the caller remembering to
+// call HashTagKey when naming the Mutex.
+func NewClusterPool(clusterClient *goredis.ClusterClient, hashTag string) redsync.Pool {
+	return &clusterPool{client: clusterClient, hashTag: hashTag}
+}
+
+// Get returns a RedisConn backed by the shared cluster client, with its
+// keys forced under this pool's hash tag. Unlike *goredis.Client,
+// *goredis.ClusterClient has no Conn method to pull a single dedicated
+// connection from, so instead Close is made a no-op here: closing the
+// shared client for real on redsync's first Redis op would break every
+// later op against this Pool.
+func (p *clusterPool) Get(ctx context.Context) redsync.RedisConn {
+	return &hashTaggingConn{RedisConn: noCloseConn{p.client}, hashTag: p.hashTag}
+}
+
+// HashTag returns the hash tag this pool was constructed with.
+func (p *clusterPool) HashTag() string {
+	return p.hashTag
+}
+
+// HashTagKey wraps name in a Redis Cluster hash tag derived from hashTag,
+// e.g. HashTagKey("redsync", "my-lock") returns "{redsync}:my-lock". Calling
+// it yourself when naming a Mutex is no longer required - clusterPool's
+// Get already applies the same tag to any key that doesn't already carry
+// one - but it's still here for callers who want the tagged name visible
+// up front, e.g. to log it.
+func HashTagKey(hashTag, name string) string {
+	if hashTag == "" {
+		return name
+	}
+	return fmt.Sprintf("{%s}:%s", hashTag, name)
+}
+
+// ensureHashTag applies HashTagKey to key, unless key already carries a
+// hash tag (i.e. already contains "{"), so a key built by an explicit
+// HashTagKey call up front doesn't get wrapped a second time.
+func ensureHashTag(hashTag, key string) string {
+	if hashTag == "" || strings.Contains(key, "{") {
+		return key
+	}
+	return HashTagKey(hashTag, key)
+}
+
+// noCloseConn wraps a long-lived, shared RedisConn (the ClusterClient) so
+// that the per-call Close redsync performs after every Get is a no-op:
+// there is no dedicated connection here to release.
+type noCloseConn struct {
+	redsync.RedisConn
+}
+
+func (noCloseConn) Close() error { return nil }
+
+// hashTaggingConn wraps a RedisConn so every key passed through it is
+// forced under hashTag via ensureHashTag, guaranteeing a Mutex's lock key
+// and its companion keys land on the same cluster slot regardless of
+// whether the caller building the Mutex's name remembered to call
+// HashTagKey.
+type hashTaggingConn struct {
+	redsync.RedisConn
+	hashTag string
+}
+
+func (c *hashTaggingConn) Get(ctx context.Context, key string) *goredis.StringCmd {
+	return c.RedisConn.Get(ctx, ensureHashTag(c.hashTag, key))
+}
+
+func (c *hashTaggingConn) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *goredis.BoolCmd {
+	return c.RedisConn.SetNX(ctx, ensureHashTag(c.hashTag, key), value, expiration)
+}
+
+func (c *hashTaggingConn) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *goredis.Cmd {
+	return c.RedisConn.Eval(ctx, script, c.taggedKeys(keys), args...)
+}
+
+func (c *hashTaggingConn) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *goredis.Cmd {
+	return c.RedisConn.EvalSha(ctx, sha1, c.taggedKeys(keys), args...)
+}
+
+func (c *hashTaggingConn) taggedKeys(keys []string) []string {
+	tagged := make([]string, len(keys))
+	for i, key := range keys {
+		tagged[i] = ensureHashTag(c.hashTag, key)
+	}
+	return tagged
+}
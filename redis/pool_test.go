@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// notClosedErr reports whether err indicates the underlying shared client
+// itself was torn down ("redis: client is closed"), as opposed to a mundane
+// failure to reach a server (these tests point at an address nothing is
+// listening on, so some failure is always expected - the point is
+// distinguishing the two).
+func notClosedErr(t *testing.T, step string, err error) {
+	t.Helper()
+	if err != nil && strings.Contains(err.Error(), "client is closed") {
+		t.Fatalf("%s: shared client was torn down by a prior Get/Close: %v", step, err)
+	}
+}
+
+// TestSentinelPoolGetCloseDoesNotKillSharedClient reproduces the bug where
+// Pool.Get returned the shared *goredis.Client directly: since redsync
+// calls Close on every RedisConn right after using it, that tore down the
+// whole client on the first op, not just a single connection, breaking
+// every later op against the Pool.
+func TestSentinelPoolGetCloseDoesNotKillSharedClient(t *testing.T) {
+	client := goredis.NewFailoverClient(&goredis.FailoverOptions{
+		MasterName:    "test-master",
+		SentinelAddrs: []string{"127.0.0.1:1"},
+	})
+	defer client.Close()
+
+	pool := &sentinelPool{client: client}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		conn := pool.Get(ctx)
+		_, err := conn.Get(ctx, "some-key").Result()
+		if closeErr := conn.Close(); closeErr != nil {
+			t.Fatalf("attempt %d: Close: %v", i, closeErr)
+		}
+		cancel()
+		notClosedErr(t, "attempt "+strconv.Itoa(i), err)
+	}
+}
+
+// TestClusterPoolGetCloseIsNoOp performs two sequential ops through the
+// same clusterPool, reproducing the same shared-client-teardown bug for the
+// Cluster adapter.
+func TestClusterPoolGetCloseIsNoOp(t *testing.T) {
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}})
+	defer client.Close()
+
+	pool := &clusterPool{client: client, hashTag: "mylock"}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		conn := pool.Get(ctx)
+		_, err := conn.Get(ctx, "some-key").Result()
+		if closeErr := conn.Close(); closeErr != nil {
+			t.Fatalf("attempt %d: Close: %v", i, closeErr)
+		}
+		cancel()
+		notClosedErr(t, "attempt "+strconv.Itoa(i), err)
+	}
+}
+
+// TestEnsureHashTagAppliesOnceRegardlessOfCallerDiscipline verifies that a
+// clusterPool forces an untagged key under its hash tag, but leaves an
+// already-tagged key alone, so the lock key and its companion fencing key
+// always land on the same cluster slot whether or not the caller bothered
+// to call HashTagKey up front.
+func TestEnsureHashTagAppliesOnceRegardlessOfCallerDiscipline(t *testing.T) {
+	const hashTag = "mylock"
+
+	if got, want := ensureHashTag(hashTag, "my-lock"), "{mylock}:my-lock"; got != want {
+		t.Fatalf("untagged key: got %q, want %q", got, want)
+	}
+	if got, want := ensureHashTag(hashTag, "my-lock:fence"), "{mylock}:my-lock:fence"; got != want {
+		t.Fatalf("untagged companion key: got %q, want %q", got, want)
+	}
+
+	pretagged := HashTagKey(hashTag, "my-lock")
+	if got := ensureHashTag(hashTag, pretagged); got != pretagged {
+		t.Fatalf("pre-tagged key got wrapped again: %q -> %q", pretagged, got)
+	}
+}
+
+// TestHashTaggingConnTagsEvalKeys verifies taggedKeys, which Eval and
+// EvalSha (how redsync's fencing script runs) apply to their keys: both of
+// the fencing script's keys come out under the same hash tag, which is what
+// keeps them on the same cluster slot.
+func TestHashTaggingConnTagsEvalKeys(t *testing.T) {
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}})
+	defer client.Close()
+
+	conn := &hashTaggingConn{RedisConn: noCloseConn{client}, hashTag: "mylock"}
+	got := conn.taggedKeys([]string{"my-lock", "my-lock:fence"})
+	want := []string{"{mylock}:my-lock", "{mylock}:my-lock:fence"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("taggedKeys[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}